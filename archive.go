@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var archiveContentTypes = map[string]string{
+	"tar.gz": "application/gzip",
+	"zip":    "application/zip",
+}
+
+// archiveCache stores git-archive output on disk, keyed by (mirror, commit
+// SHA, format), and evicts the least recently used entries once the total
+// size on disk exceeds maxSize.
+type archiveCache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+func newArchiveCache(dir string, maxSize int64) *archiveCache {
+	return &archiveCache{dir: dir, maxSize: maxSize}
+}
+
+func (c *archiveCache) path(mirrorName, sha, format string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%s.%s", mirrorName, sha, format))
+}
+
+// get returns the cached archive path if it already exists on disk. A hit
+// touches the file's mtime so evict's LRU ordering reflects last access,
+// not just when the archive was first built.
+func (c *archiveCache) get(mirrorName, sha, format string) (string, bool) {
+	path := c.path(mirrorName, sha, format)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+// put builds the archive for (mirrorName, sha, format) if it isn't already
+// cached, then evicts the oldest entries until the cache fits within
+// maxSize.
+func (c *archiveCache) put(ctx context.Context, storagePath, mirrorName, sha, format string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(mirrorName, sha, format)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return "", fmt.Errorf("cannot create archive cache dir: %s", err)
+	}
+
+	tempFile, err := os.CreateTemp(c.dir, "archive.*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary archive file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if err := gitArchive(ctx, storagePath, mirrorName, sha, format, tempFile); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temp archive file: %s", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move archive into cache: %s", err)
+	}
+
+	c.evict()
+
+	return path, nil
+}
+
+func (c *archiveCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	type cacheEntry struct {
+		path string
+		info os.FileInfo
+	}
+
+	var files []cacheEntry
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheEntry{path: filepath.Join(c.dir, entry.Name()), info: info})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		total -= f.info.Size()
+	}
+}
+
+// gitResolveRev resolves rev to a commit SHA within the mirror's repository.
+func gitResolveRev(ctx context.Context, storagePath, mirrorName, rev string) (string, error) {
+	dir := filepath.Join(storagePath, mirrorName)
+
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--verify", rev+"^{commit}")
+	out, err := cmd.Output()
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return "", fmt.Errorf("rev-parse failed: %s\n%s\n", err, exitError.Stderr)
+		}
+		return "", fmt.Errorf("rev-parse failed: %s", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitArchive runs `git archive` for rev in the given format, writing the
+// resulting archive to w.
+func gitArchive(ctx context.Context, storagePath, mirrorName, rev, format string, w *os.File) error {
+	dir := filepath.Join(storagePath, mirrorName)
+
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "archive", "--format="+format, rev)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("archive failed: %s", err)
+	}
+
+	return nil
+}
+
+func (m *Mirrorer) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !checkArgs(w, q, "name", "rev") {
+		return
+	}
+
+	mirrorName := q.Get("name")
+	rev := q.Get("rev")
+	format := q.Get("format")
+	if format == "" {
+		format = "tar.gz"
+	}
+
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported archive format: %s", format), 400)
+		return
+	}
+
+	m.mu.Lock()
+	_, exists := m.state.Mirrors[mirrorName]
+	m.mu.Unlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("unknown mirror: %s", mirrorName), 404)
+		return
+	}
+
+	sha, err := gitResolveRev(r.Context(), m.state.StoragePath, mirrorName, rev)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot resolve rev '%s': %s", rev, err), 500)
+		return
+	}
+
+	if match := r.Header.Get("If-None-Match"); match == sha {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	path, hit := m.archiveCache.get(mirrorName, sha, format)
+	if !hit {
+		path, err = m.archiveCache.put(r.Context(), m.state.StoragePath, mirrorName, sha, format)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to build archive: %s", err), 500)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, mirrorName, sha, format))
+	w.Header().Set("ETag", sha)
+	http.ServeFile(w, r, path)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
@@ -9,8 +11,12 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -56,33 +62,114 @@ func (ms *MirrorState) UnmarshalText(bs []byte) error {
 	return nil
 }
 
+type MirrorDirection int
+
+const (
+	DirectionPull MirrorDirection = iota + 1
+	DirectionPush
+)
+
+func (d MirrorDirection) String() string {
+	switch d {
+	case DirectionPull:
+		return "pull"
+	case DirectionPush:
+		return "push"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseMirrorDirection(str string) (MirrorDirection, error) {
+	switch str {
+	case "", "pull":
+		return DirectionPull, nil
+	case "push":
+		return DirectionPush, nil
+	}
+	return 0, fmt.Errorf("invalid MirrorDirection: %s", str)
+}
+
+func (d MirrorDirection) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *MirrorDirection) UnmarshalText(bs []byte) error {
+	val, err := ParseMirrorDirection(string(bs))
+	if err != nil {
+		return err
+	}
+
+	*d = val
+	return nil
+}
+
 type Mirror struct {
-	Url        string      `json:"url"`
-	LastUpdate time.Time   `json:"last_update"`
-	State      MirrorState `json:"state"`
+	Url        string          `json:"url"`
+	LastUpdate time.Time       `json:"last_update"`
+	State      MirrorState     `json:"state"`
+	Direction  MirrorDirection `json:"direction"`
+
+	// RemoteName is the name under which Url is registered as a remote in
+	// the source mirror's repository. Only set for push mirrors.
+	RemoteName string `json:"remote_name,omitempty"`
+
+	// Source is the name of the locally pull-mirrored repository this push
+	// mirror pushes from. Only set for push mirrors.
+	Source string `json:"source,omitempty"`
+
+	// LastError, ConsecutiveFailures and NextUpdate reflect the scheduler's
+	// retry/backoff bookkeeping, so that /api/list-mirrors exposes real
+	// health instead of just the last successful update.
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"`
+	NextUpdate          time.Time `json:"next_update,omitempty"`
+
+	// Interval overrides State.UpdateDelta for this mirror when positive;
+	// zero or negative values fall back to State.UpdateDelta.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// EnablePrune makes pull updates run with `--prune`, so branches
+	// deleted upstream disappear from the local mirror too.
+	EnablePrune bool `json:"enable_prune,omitempty"`
+
+	// WebhookSecret authenticates POSTs to /api/webhook/<name>. Empty means
+	// no webhook is configured for this mirror.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
 }
 
 type State struct {
-	StoragePath string            `json:"storage_path"`
-	UpdateDelta time.Duration     `json:"update_delta"`
-	Mirrors     map[string]Mirror `json:"mirrors"`
+	StoragePath          string            `json:"storage_path"`
+	UpdateDelta          time.Duration     `json:"update_delta"`
+	Mirrors              map[string]Mirror `json:"mirrors"`
+	ArchiveCachePath     string            `json:"archive_cache_path"`
+	ArchiveCacheMaxSize  int64             `json:"archive_cache_max_size"`
+	MaxConcurrentUpdates int               `json:"max_concurrent_updates"`
 }
 
 func NewState() State {
-	return State{UpdateDelta: 5 * time.Minute, Mirrors: make(map[string]Mirror)}
-}
-
-type Message struct {
-	mirrorName string
+	return State{
+		UpdateDelta:          5 * time.Minute,
+		Mirrors:              make(map[string]Mirror),
+		ArchiveCachePath:     "archives",
+		ArchiveCacheMaxSize:  1 << 30, // 1 GiB
+		MaxConcurrentUpdates: 4,
+	}
 }
 
 type Mirrorer struct {
-	state    State
-	dbPath   string
-	messages chan string
+	state        State
+	dbPath       string
+	archiveCache *archiveCache
+	sched        *scheduler
+	webhooks     *webhookDedup
+
+	ctx context.Context
+
+	mu sync.Mutex
 }
 
-func (m Mirrorer) updateDb() error {
+func (m *Mirrorer) updateDb() error {
 	log.Println("updating db")
 
 	tempFile, err := os.CreateTemp(filepath.Dir(m.dbPath), "looter.*.tmp")
@@ -108,7 +195,7 @@ func (m Mirrorer) updateDb() error {
 	return nil
 }
 
-func NewMirrorer(dbPath string) (*Mirrorer, error) {
+func NewMirrorer(ctx context.Context, dbPath string) (*Mirrorer, error) {
 	if !fs.ValidPath(dbPath) {
 		return nil, fmt.Errorf("invalid db path: '%s'", dbPath)
 	}
@@ -126,7 +213,13 @@ func NewMirrorer(dbPath string) (*Mirrorer, error) {
 	log.Printf("read state from '%s'\n", dbPath)
 
 	mirrorer := &Mirrorer{
-		dbPath: dbPath, state: state, messages: make(chan string)}
+		dbPath:       dbPath,
+		state:        state,
+		archiveCache: newArchiveCache(state.ArchiveCachePath, state.ArchiveCacheMaxSize),
+		sched:        newScheduler(state.MaxConcurrentUpdates),
+		webhooks:     newWebhookDedup(),
+		ctx:          ctx,
+	}
 	mirrorer.updateDb()
 	go mirrorer.Run()
 
@@ -149,6 +242,30 @@ func checkArgs(w http.ResponseWriter, values url.Values, args ...string) bool {
 	return true
 }
 
+// parseOptionalDuration parses str as a time.Duration, returning the zero
+// duration for an empty string.
+func parseOptionalDuration(str string) (time.Duration, error) {
+	if str == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(str)
+}
+
+// validMirrorName matches the characters a mirror name may contain. Names
+// become path segments under State.StoragePath and archive cache filenames,
+// so this rules out "/" and other characters that could escape those
+// directories. A name made up entirely of dots (".", "..", ...) is excluded
+// separately below, since the charset alone can't rule out "." or ".."
+// collapsing via filepath.Join/Clean into the storage root or its parent.
+var validMirrorName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// allDots reports whether name consists only of '.' characters (".", "..",
+// and so on), the one shape validMirrorName's charset can't reject on its
+// own.
+func allDots(name string) bool {
+	return strings.Trim(name, ".") == ""
+}
+
 func (m *Mirrorer) AddMirrorHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	if !checkArgs(w, q, "name", "url") {
@@ -158,6 +275,29 @@ func (m *Mirrorer) AddMirrorHandler(w http.ResponseWriter, r *http.Request) {
 	mirrorName := q.Get("name")
 	mirrorUrl := q.Get("url")
 
+	if !validMirrorName.MatchString(mirrorName) || allDots(mirrorName) {
+		http.Error(w, fmt.Sprintf("invalid mirror name: %s", mirrorName), 500)
+		return
+	}
+
+	direction, err := ParseMirrorDirection(q.Get("direction"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	interval, err := parseOptionalDuration(q.Get("interval"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid interval: %s", err), 500)
+		return
+	}
+
+	enablePrune := q.Get("enable_prune") == "true"
+	webhookSecret := q.Get("webhook_secret")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if _, exists := m.state.Mirrors[mirrorName]; exists {
 		http.Error(
 			w,
@@ -165,19 +305,84 @@ func (m *Mirrorer) AddMirrorHandler(w http.ResponseWriter, r *http.Request) {
 			500)
 		return
 	}
-	m.state.Mirrors[mirrorName] = Mirror{
-		Url:        mirrorUrl,
-		LastUpdate: time.Now(),
-		State:      MirrorFresh,
+
+	var mirror Mirror
+	if direction == DirectionPush {
+		if !checkArgs(w, q, "source") {
+			return
+		}
+		sourceName := q.Get("source")
+		source, exists := m.state.Mirrors[sourceName]
+		if !exists || source.Direction != DirectionPull {
+			http.Error(
+				w,
+				fmt.Sprintf("cannot add push mirror '%s': source '%s' is not a pull mirror", mirrorName, sourceName),
+				500)
+			return
+		}
+
+		sourceDir := filepath.Join(m.state.StoragePath, sourceName)
+		collision, err := gitRemoteExists(m.ctx, sourceDir, mirrorName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to check remotes for source '%s': %s", sourceName, err), 500)
+			return
+		}
+		if collision {
+			http.Error(
+				w,
+				fmt.Sprintf("cannot add push mirror '%s': a remote named '%s' already exists in source '%s'", mirrorName, mirrorName, sourceName),
+				500)
+			return
+		}
+
+		mirror = Mirror{
+			Url:           mirrorUrl,
+			LastUpdate:    time.Now(),
+			State:         MirrorReady,
+			Direction:     DirectionPush,
+			RemoteName:    mirrorName,
+			Source:        sourceName,
+			Interval:      interval,
+			EnablePrune:   enablePrune,
+			WebhookSecret: webhookSecret,
+		}
+	} else {
+		// Clone right away, rather than leaving the mirror Fresh for Run's
+		// startup loop to pick up, so a pull mirror added through this
+		// endpoint is actually scheduled without requiring a restart.
+		if err := gitCloneMirror(m.ctx, m.state.StoragePath, mirrorName, mirrorUrl); err != nil {
+			http.Error(w, fmt.Sprintf("failed to clone mirror: %s", err), 500)
+			return
+		}
+
+		mirror = Mirror{
+			Url:           mirrorUrl,
+			LastUpdate:    time.Now(),
+			State:         MirrorReady,
+			Direction:     DirectionPull,
+			Interval:      interval,
+			EnablePrune:   enablePrune,
+			WebhookSecret: webhookSecret,
+		}
 	}
 
+	m.state.Mirrors[mirrorName] = mirror
 	if err := m.updateDb(); err != nil {
 		http.Error(w, fmt.Sprintf("failed to update db: %s", err), 500)
 		return
 	}
+
+	if mirror.Direction == DirectionPush {
+		m.sched.arm(mirrorName, time.Now())
+	} else {
+		m.sched.arm(mirrorName, mirror.LastUpdate.Add(m.interval(mirror)))
+	}
 }
 
 func (m *Mirrorer) ListMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if err := json.NewEncoder(w).Encode(m.state.Mirrors); err != nil {
 		http.Error(w, err.Error(), 500)
 	}
@@ -187,13 +392,22 @@ func (m *Mirrorer) Routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/add-mirror", m.AddMirrorHandler)
 	mux.HandleFunc("/api/list-mirrors", m.ListMirrorsHandler)
+	mux.HandleFunc("/api/archive", m.ArchiveHandler)
+	mux.HandleFunc("/api/update-mirror", m.UpdateMirrorHandler)
+	mux.HandleFunc("/api/sync-now", m.SyncNowHandler)
+	mux.HandleFunc("/api/remove-mirror", m.RemoveMirrorHandler)
+	mux.HandleFunc("/api/webhook/", m.WebhookHandler)
 	return mux
 }
 
+// Run clones any mirror left in the Fresh state, arms the scheduler with
+// every Ready mirror's next update time, then starts the worker pool and
+// blocks until ctx is cancelled.
 func (m *Mirrorer) Run() {
+	m.mu.Lock()
 	for mirrorName, mirror := range m.state.Mirrors {
 		if mirror.State == MirrorFresh {
-			if err := gitCloneMirror(m.state.StoragePath, mirrorName, mirror.Url); err != nil {
+			if err := gitCloneMirror(m.ctx, m.state.StoragePath, mirrorName, mirror.Url); err != nil {
 				log.Println(err)
 			}
 			mirror.State = MirrorReady
@@ -207,51 +421,133 @@ func (m *Mirrorer) Run() {
 
 	for mirrorName, mirror := range m.state.Mirrors {
 		if mirror.State == MirrorReady {
-			nextUpdateTime := mirror.LastUpdate.Add(m.state.UpdateDelta)
+			nextUpdateTime := mirror.LastUpdate.Add(m.interval(mirror))
 			fmt.Printf("will update '%s' at '%s'\n", mirrorName, nextUpdateTime)
-			go func() {
-				time.Sleep(time.Until(nextUpdateTime))
-				m.messages <- mirrorName
-			}()
+			m.sched.arm(mirrorName, nextUpdateTime)
 		}
 	}
+	m.mu.Unlock()
+
+	go m.sched.run(m.ctx)
+
+	workers := m.state.MaxConcurrentUpdates
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
 
+	<-m.ctx.Done()
+}
+
+// worker pulls due mirror names off the scheduler's job queue and updates
+// them, rearming the schedule on success or requeuing with exponential
+// backoff on failure.
+func (m *Mirrorer) worker() {
 	for {
-		mirrorName := <-m.messages
-		mirror := m.state.Mirrors[mirrorName]
-		if mirror.State == MirrorReady {
-			fmt.Println("updating", mirrorName)
-			if err := gitRemoteUpdate(m.state.StoragePath, mirrorName); err != nil {
-				log.Println("failed to update mirror:", err)
-			} else {
-				fmt.Println("successfully updated", mirrorName)
-				mirror.LastUpdate = time.Now()
-				m.state.Mirrors[mirrorName] = mirror
+		var mirrorName string
+		select {
+		case <-m.ctx.Done():
+			return
+		case mirrorName = <-m.sched.jobs:
+		}
+
+		m.mu.Lock()
+		mirror, exists := m.state.Mirrors[mirrorName]
+		m.mu.Unlock()
+		if !exists || mirror.State != MirrorReady {
+			m.sched.done(mirrorName)
+			continue
+		}
+
+		fmt.Println("updating", mirrorName)
+		if err := m.updateMirror(mirrorName, mirror); err != nil {
+			log.Println("failed to update mirror:", err)
+
+			// Re-read the mirror under the lock rather than writing back
+			// the snapshot taken before the (possibly long) git operation,
+			// so a concurrent /api/update-mirror isn't clobbered by a
+			// stale Interval/EnablePrune once this update finishes.
+			m.mu.Lock()
+			current, exists := m.state.Mirrors[mirrorName]
+			var next time.Time
+			if exists {
+				current.LastError = err.Error()
+				current.ConsecutiveFailures++
+				next = time.Now().Add(backoff(current.ConsecutiveFailures))
+				current.NextUpdate = next
+				m.state.Mirrors[mirrorName] = current
 				m.updateDb()
-				nextUpdateTime := mirror.LastUpdate.Add(m.state.UpdateDelta)
-				fmt.Printf("will update '%s' at '%s'\n", mirrorName, nextUpdateTime)
-				go func() {
-					time.Sleep(time.Until(nextUpdateTime))
-					m.messages <- mirrorName
-				}()
 			}
+			m.mu.Unlock()
+
+			m.sched.done(mirrorName)
+			if exists {
+				m.sched.arm(mirrorName, next)
+			}
+			continue
+		}
+
+		fmt.Println("successfully updated", mirrorName)
+
+		m.mu.Lock()
+		current, exists := m.state.Mirrors[mirrorName]
+		var next time.Time
+		if exists {
+			current.LastUpdate = time.Now()
+			current.LastError = ""
+			current.ConsecutiveFailures = 0
+			next = current.LastUpdate.Add(m.interval(current))
+			current.NextUpdate = next
+			m.state.Mirrors[mirrorName] = current
+			m.updateDb()
 		}
+		m.mu.Unlock()
+
+		m.sched.done(mirrorName)
+		if exists {
+			fmt.Printf("will update '%s' at '%s'\n", mirrorName, next)
+			m.sched.arm(mirrorName, next)
+		}
+	}
+}
+
+func (m *Mirrorer) updateMirror(mirrorName string, mirror Mirror) error {
+	switch mirror.Direction {
+	case DirectionPush:
+		return gitPushMirror(m.ctx, m.state.StoragePath, mirror.Source, mirror.RemoteName, mirror.Url)
+	default:
+		return gitRemoteUpdate(m.ctx, m.state.StoragePath, mirrorName, mirror.EnablePrune)
+	}
+}
+
+// interval returns the mirror's own update interval if it has one, falling
+// back to the global State.UpdateDelta.
+func (m *Mirrorer) interval(mirror Mirror) time.Duration {
+	if mirror.Interval > 0 {
+		return mirror.Interval
 	}
+	return m.state.UpdateDelta
 }
 
-func gitCloneMirror(storagePath string, mirrorName string, mirrorUrl string) error {
+func gitCloneMirror(ctx context.Context, storagePath string, mirrorName string, mirrorUrl string) error {
 	dir := filepath.Join(storagePath, mirrorName)
 
 	if err := os.RemoveAll(dir); err != nil {
 		return fmt.Errorf("failed to clean target dir: %s", err)
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+
 	log.Printf("mirroring '%s' -> '%s'\n", mirrorName, dir)
-	cmd := exec.Command("git", "clone", "--mirror", mirrorUrl, dir)
+	cmd := exec.CommandContext(ctx, "git", "clone", "--mirror", mirrorUrl, dir)
+	cmd.Env = noPromptEnv()
 	_, err := cmd.Output()
 	if err != nil {
-		exitError := err.(*exec.ExitError)
-		if exitError != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
 			return fmt.Errorf("mirror failed: %s\n%s\n", err, exitError.Stderr)
 		}
 		return fmt.Errorf("mirror failed: %s", err)
@@ -261,14 +557,22 @@ func gitCloneMirror(storagePath string, mirrorName string, mirrorUrl string) err
 	return nil
 }
 
-func gitRemoteUpdate(storagePath string, mirrorName string) error {
+func gitRemoteUpdate(ctx context.Context, storagePath string, mirrorName string, prune bool) error {
 	dir := filepath.Join(storagePath, mirrorName)
 
-	cmd := exec.Command("git", "-C", dir, "remote", "update")
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+
+	args := []string{"-C", dir, "remote", "update"}
+	if prune {
+		args = append(args, "--prune")
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = noPromptEnv()
 	_, err := cmd.Output()
 	if err != nil {
-		exitError := err.(*exec.ExitError)
-		if exitError != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
 			return fmt.Errorf("update failed: %s\n%s\n", err, exitError.Stderr)
 		}
 		return fmt.Errorf("update failed: %s", err)
@@ -278,10 +582,24 @@ func gitRemoteUpdate(storagePath string, mirrorName string) error {
 }
 
 func main() {
-	m, err := NewMirrorer("db.json")
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	m, err := NewMirrorer(ctx, "db.json")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Fatal(http.ListenAndServe(":8080", m.Routes()))
+	server := &http.Server{Addr: ":8080", Handler: m.Routes()}
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
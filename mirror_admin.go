@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UpdateMirrorHandler changes a mirror's own Interval and/or EnablePrune
+// settings without touching its schedule position.
+func (m *Mirrorer) UpdateMirrorHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !checkArgs(w, q, "name") {
+		return
+	}
+	mirrorName := q.Get("name")
+
+	interval, err := parseOptionalDuration(q.Get("interval"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid interval: %s", err), 500)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mirror, exists := m.state.Mirrors[mirrorName]
+	if !exists {
+		http.Error(w, fmt.Sprintf("unknown mirror: %s", mirrorName), 404)
+		return
+	}
+
+	if q.Has("interval") {
+		mirror.Interval = interval
+	}
+	if q.Has("enable_prune") {
+		mirror.EnablePrune = q.Get("enable_prune") == "true"
+	}
+	m.state.Mirrors[mirrorName] = mirror
+
+	if err := m.updateDb(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to update db: %s", err), 500)
+		return
+	}
+}
+
+// SyncNowHandler pushes a mirror's next update to right now, so it runs on
+// the scheduler's next tick instead of waiting for its regular interval.
+func (m *Mirrorer) SyncNowHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !checkArgs(w, q, "name") {
+		return
+	}
+	mirrorName := q.Get("name")
+
+	m.mu.Lock()
+	_, exists := m.state.Mirrors[mirrorName]
+	m.mu.Unlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("unknown mirror: %s", mirrorName), 404)
+		return
+	}
+
+	m.sched.arm(mirrorName, time.Now())
+}
+
+// RemoveMirrorHandler stops scheduling a mirror and deletes its on-disk
+// clone.
+func (m *Mirrorer) RemoveMirrorHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !checkArgs(w, q, "name") {
+		return
+	}
+	mirrorName := q.Get("name")
+
+	m.mu.Lock()
+	mirror, exists := m.state.Mirrors[mirrorName]
+	if !exists {
+		m.mu.Unlock()
+		http.Error(w, fmt.Sprintf("unknown mirror: %s", mirrorName), 404)
+		return
+	}
+	delete(m.state.Mirrors, mirrorName)
+	err := m.updateDb()
+	m.mu.Unlock()
+
+	m.sched.remove(mirrorName)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to update db: %s", err), 500)
+		return
+	}
+
+	if mirror.Direction != DirectionPush {
+		dir := filepath.Join(m.state.StoragePath, mirrorName)
+		if err := os.RemoveAll(dir); err != nil {
+			http.Error(w, fmt.Sprintf("failed to remove mirror directory: %s", err), 500)
+			return
+		}
+	}
+}
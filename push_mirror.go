@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitCommandTimeout bounds how long a single git subprocess may run, so a
+// stalled clone/update/push can't permanently occupy one of the
+// scheduler's limited workers.
+const gitCommandTimeout = 15 * time.Minute
+
+// noPromptEnv returns an environment for git subprocesses that talk to
+// remotes, arranged so that missing credentials fail fast instead of
+// blocking the scheduler on an interactive prompt.
+func noPromptEnv() []string {
+	return append(
+		os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ASKPASS=true",
+		"SSH_ASKPASS=true",
+		"GIT_SSH_COMMAND=ssh -o BatchMode=yes",
+	)
+}
+
+// gitPushMirror pushes the locally mirrored repository sourceName to
+// remoteUrl, registered as remote remoteName, using `git push --mirror`,
+// the outward counterpart of gitCloneMirror/gitRemoteUpdate.
+func gitPushMirror(ctx context.Context, storagePath string, sourceName string, remoteName string, remoteUrl string) error {
+	dir := filepath.Join(storagePath, sourceName)
+
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+
+	if err := gitEnsureRemote(ctx, dir, remoteName, remoteUrl); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "push", "--mirror", remoteName)
+	cmd.Env = noPromptEnv()
+	_, err := cmd.Output()
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return fmt.Errorf("push mirror failed: %s\n%s\n", err, exitError.Stderr)
+		}
+		return fmt.Errorf("push mirror failed: %s", err)
+	}
+
+	return nil
+}
+
+// gitRemoteExists reports whether dir already has a remote named
+// remoteName, so callers can refuse to register a push mirror under a name
+// that would repoint a remote it doesn't own — most importantly "origin",
+// which every mirrored clone already uses for its own upstream.
+func gitRemoteExists(ctx context.Context, dir string, remoteName string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "remote")
+	cmd.Env = noPromptEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return false, fmt.Errorf("failed to list remotes: %s\n%s\n", err, exitError.Stderr)
+		}
+		return false, fmt.Errorf("failed to list remotes: %s", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == remoteName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// gitEnsureRemote makes sure dir has a remote named remoteName pointing at
+// remoteUrl, adding it if missing and repointing it if the URL changed, so
+// repeated pushes reuse the named remote instead of re-resolving the raw
+// URL on every push.
+func gitEnsureRemote(ctx context.Context, dir string, remoteName string, remoteUrl string) error {
+	getUrl := exec.CommandContext(ctx, "git", "-C", dir, "remote", "get-url", remoteName)
+	getUrl.Env = noPromptEnv()
+	out, err := getUrl.Output()
+	if err == nil && strings.TrimSpace(string(out)) == remoteUrl {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if err == nil {
+		cmd = exec.CommandContext(ctx, "git", "-C", dir, "remote", "set-url", remoteName, remoteUrl)
+	} else {
+		cmd = exec.CommandContext(ctx, "git", "-C", dir, "remote", "add", remoteName, remoteUrl)
+	}
+	cmd.Env = noPromptEnv()
+	if _, err := cmd.Output(); err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return fmt.Errorf("failed to configure remote '%s': %s\n%s\n", remoteName, err, exitError.Stderr)
+		}
+		return fmt.Errorf("failed to configure remote '%s': %s", remoteName, err)
+	}
+
+	return nil
+}
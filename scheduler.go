@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	backoffBase = 10 * time.Second
+	backoffMax  = 30 * time.Minute
+)
+
+// backoff returns the delay before retrying a mirror that has failed
+// attempt times in a row: min(base * 2^attempt, max), plus up to 25%
+// jitter so that failing mirrors don't all retry in lockstep.
+func backoff(attempt int) time.Duration {
+	d := time.Duration(float64(backoffBase) * math.Pow(2, float64(attempt)))
+	if d <= 0 || d > backoffMax {
+		d = backoffMax
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+// scheduleItem is one entry in the scheduler's min-heap: a mirror due for
+// an update at at.
+type scheduleItem struct {
+	name  string
+	at    time.Time
+	index int
+}
+
+type scheduleHeap []*scheduleItem
+
+func (h scheduleHeap) Len() int            { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool  { return h[i].at.Before(h[j].at) }
+func (h scheduleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].index = i; h[j].index = j }
+func (h *scheduleHeap) Push(x interface{}) {
+	item := x.(*scheduleItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// scheduler drives mirror updates from a min-heap of due times through a
+// job queue that a fixed-size pool of workers consumes. It de-duplicates
+// pending entries so a mirror already queued is never queued twice.
+type scheduler struct {
+	mu     sync.Mutex
+	heap   scheduleHeap
+	items  map[string]*scheduleItem
+	queued map[string]bool
+	rerun  map[string]bool
+	wake   chan struct{}
+
+	jobs chan string
+}
+
+func newScheduler(workers int) *scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &scheduler{
+		items:  make(map[string]*scheduleItem),
+		queued: make(map[string]bool),
+		rerun:  make(map[string]bool),
+		wake:   make(chan struct{}, 1),
+		jobs:   make(chan string, workers),
+	}
+}
+
+// arm (re)schedules name to become due at at, replacing any existing entry
+// for the same mirror.
+func (s *scheduler) arm(name string, at time.Time) {
+	s.mu.Lock()
+	if item, ok := s.items[name]; ok {
+		item.at = at
+		heap.Fix(&s.heap, item.index)
+	} else {
+		item := &scheduleItem{name: name, at: at}
+		heap.Push(&s.heap, item)
+		s.items[name] = item
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove takes name out of the heap, if it's there, so it won't be
+// scheduled for a future update.
+func (s *scheduler) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[name]
+	if !ok {
+		return
+	}
+	heap.Remove(&s.heap, item.index)
+	delete(s.items, name)
+}
+
+// done clears name from the pending set once a worker has finished with it.
+// If name came due again while it was in flight, it couldn't be queued (it
+// was already pending) and was recorded as a rerun instead, so done
+// re-enqueues name immediately rather than dropping it.
+func (s *scheduler) done(name string) {
+	s.mu.Lock()
+	rerun := s.rerun[name]
+	delete(s.rerun, name)
+	delete(s.queued, name)
+	s.mu.Unlock()
+
+	if rerun {
+		s.enqueue(name)
+	}
+}
+
+// enqueue pushes name onto the job queue, unless it's already pending, in
+// which case it records that name must run again once the in-flight update
+// finishes.
+func (s *scheduler) enqueue(name string) {
+	s.mu.Lock()
+	if s.queued[name] {
+		s.rerun[name] = true
+		s.mu.Unlock()
+		return
+	}
+	s.queued[name] = true
+	s.mu.Unlock()
+
+	s.jobs <- name
+}
+
+// run pops due items off the heap onto the job queue, waking early whenever
+// arm schedules something sooner than the current wait.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.heap) > 0 {
+			wait = time.Until(s.heap[0].at)
+		}
+		s.mu.Unlock()
+
+		if wait <= 0 {
+			s.mu.Lock()
+			item := heap.Pop(&s.heap).(*scheduleItem)
+			delete(s.items, item.name)
+			s.mu.Unlock()
+			s.enqueue(item.name)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webhookReplayWindow = 5 * time.Minute
+
+// webhookDedup tracks recently seen delivery IDs so a retried webhook
+// delivery doesn't trigger a second update.
+type webhookDedup struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newWebhookDedup() *webhookDedup {
+	return &webhookDedup{seen: make(map[string]time.Time)}
+}
+
+// seenRecently reports whether id was already recorded within the replay
+// window, then records it (or refreshes its timestamp) for next time.
+func (d *webhookDedup) seenRecently(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for seenID, at := range d.seen {
+		if now.Sub(at) > webhookReplayWindow {
+			delete(d.seen, seenID)
+		}
+	}
+
+	_, duplicate := d.seen[id]
+	d.seen[id] = now
+	return duplicate
+}
+
+// verifyHmacSignature reports whether signatureHeader (in the
+// "sha256=<hex>" or bare-hex form used by GitHub/Gitea) matches the
+// HMAC-SHA256 of body computed with secret.
+func verifyHmacSignature(secret, signatureHeader string, body []byte) bool {
+	if secret == "" || signatureHeader == "" {
+		return false
+	}
+
+	signatureHeader = strings.TrimPrefix(signatureHeader, "sha256=")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signatureHeader))
+}
+
+func isPushEventType(eventType string) bool {
+	switch eventType {
+	case "push", "Push Hook":
+		return true
+	default:
+		return false
+	}
+}
+
+// WebhookHandler accepts push notifications from GitHub, GitLab and Gitea
+// for the mirror named in the URL path, checks them against the mirror's
+// configured secret, and enqueues an immediate update on a valid,
+// non-replayed push event. GitHub and Gitea requests must carry an
+// HMAC-SHA256 signature of the body; GitLab requests are checked only by
+// comparing X-Gitlab-Token with the secret, with no signature of the body.
+func (m *Mirrorer) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	mirrorName := strings.TrimPrefix(r.URL.Path, "/api/webhook/")
+	if mirrorName == "" {
+		http.Error(w, "missing mirror name", http.StatusNotFound)
+		return
+	}
+
+	m.mu.Lock()
+	mirror, exists := m.state.Mirrors[mirrorName]
+	m.mu.Unlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("unknown mirror: %s", mirrorName), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var eventType, deliveryID string
+	var valid bool
+
+	switch {
+	case r.Header.Get("X-GitHub-Event") != "":
+		eventType = r.Header.Get("X-GitHub-Event")
+		deliveryID = r.Header.Get("X-GitHub-Delivery")
+		valid = verifyHmacSignature(mirror.WebhookSecret, r.Header.Get("X-Hub-Signature-256"), body)
+	case r.Header.Get("X-Gitea-Event") != "":
+		eventType = r.Header.Get("X-Gitea-Event")
+		deliveryID = r.Header.Get("X-Gitea-Delivery")
+		valid = verifyHmacSignature(mirror.WebhookSecret, r.Header.Get("X-Gitea-Signature"), body)
+	case r.Header.Get("X-Gitlab-Event") != "":
+		eventType = r.Header.Get("X-Gitlab-Event")
+		deliveryID = r.Header.Get("X-Gitlab-Event-UUID")
+		valid = mirror.WebhookSecret != "" && hmac.Equal([]byte(r.Header.Get("X-Gitlab-Token")), []byte(mirror.WebhookSecret))
+	default:
+		http.Error(w, "unrecognized webhook source", http.StatusBadRequest)
+		return
+	}
+
+	if !valid {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if m.webhooks.seenRecently(deliveryID) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if isPushEventType(eventType) {
+		m.sched.arm(mirrorName, time.Now())
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}